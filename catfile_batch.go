@@ -0,0 +1,199 @@
+package gitlib
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CatFileBatch is a persistent `git cat-file --batch` subprocess. Unlike
+// ReadCatFile, which forks a new git process per object, a CatFileBatch is
+// opened once and then reused for every subsequent object lookup, which
+// matters when thousands of objects need to be read (e.g. restoring a tree).
+//
+// A CatFileBatch serializes access: only one Read or Exists call may be in
+// flight at a time. Callers that want parallelism should open one
+// CatFileBatch per goroutine.
+type CatFileBatch struct {
+	mu sync.Mutex
+
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+
+	checkCmd    *exec.Cmd
+	checkStdin  io.WriteCloser
+	checkStdout *bufio.Reader
+}
+
+// OpenCatFileBatch spawns `git cat-file --batch` and `git cat-file --batch-check`
+// subprocesses and holds their pipes open for repeated object lookups. The
+// returned CatFileBatch must be closed with Close once no longer needed.
+func (g *Transaction) OpenCatFileBatch() (*CatFileBatch, error) {
+	return g.OpenCatFileBatchCtx(context.Background())
+}
+
+// OpenCatFileBatchCtx is like OpenCatFileBatch but ctx governs the lifetime
+// of the spawned subprocesses: cancelling it kills them.
+func (g *Transaction) OpenCatFileBatchCtx(ctx context.Context) (b *CatFileBatch, err error) {
+	b = &CatFileBatch{}
+
+	b.cmd, b.stdin, b.stdout, err = g.startCatFileProcess(ctx, "--batch")
+	if err != nil {
+		return nil, err
+	}
+
+	b.checkCmd, b.checkStdin, b.checkStdout, err = g.startCatFileProcess(ctx, "--batch-check")
+	if err != nil {
+		b.stdin.Close()
+		b.cmd.Wait()
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (g *Transaction) startCatFileProcess(ctx context.Context, mode string) (cmd *exec.Cmd, stdin io.WriteCloser, stdout *bufio.Reader, err error) {
+	cmd, _ = g.cmdCtx(ctx, "cat-file", mode)
+	stdin, err = cmd.StdinPipe()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, nil, nil, err
+	}
+	return cmd, stdin, bufio.NewReader(out), nil
+}
+
+// Read requests the object with the given hash and returns its type, its
+// size in bytes and a reader for its payload. The returned ReadCloser must
+// be closed (which discards any unread payload and the trailing framing
+// newline and releases the batch; it does not affect the underlying batch
+// process) before the next Read or ExistsBatch call.
+func (b *CatFileBatch) Read(hash Hash) (objType string, size int64, rc io.ReadCloser, err error) {
+	b.mu.Lock()
+
+	if _, err = fmt.Fprintln(b.stdin, hash.String()); err != nil {
+		b.mu.Unlock()
+		return "", 0, nil, err
+	}
+
+	objType, size, err = readBatchHeader(b.stdout, hash)
+	if err != nil {
+		b.mu.Unlock()
+		return "", 0, nil, err
+	}
+
+	return objType, size, &batchObjectReader{
+		r:      io.LimitReader(b.stdout, size),
+		trail:  b.stdout,
+		unlock: b.mu.Unlock,
+	}, nil
+}
+
+// ExistsBatch reports whether the object with the given hash exists in the
+// repository, using the `--batch-check` side channel.
+func (b *CatFileBatch) ExistsBatch(hash Hash) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, err := fmt.Fprintln(b.checkStdin, hash.String()); err != nil {
+		return false, err
+	}
+
+	line, err := b.checkStdout.ReadString('\n')
+	if err != nil {
+		return false, err
+	}
+	line = strings.TrimSuffix(line, "\n")
+	return !strings.HasSuffix(line, "missing"), nil
+}
+
+// readBatchHeader parses the `<sha> <type> <size>\n` line git cat-file
+// --batch writes before an object's payload.
+func readBatchHeader(r *bufio.Reader, want Hash) (objType string, size int64, err error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", 0, err
+	}
+	line = strings.TrimSuffix(line, "\n")
+
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return "", 0, fmt.Errorf("gitlib: unexpected cat-file --batch response %q", line)
+	}
+	if fields[0] != want.String() {
+		return "", 0, fmt.Errorf("gitlib: cat-file --batch returned object %q, wanted %q", fields[0], want)
+	}
+	size, err = strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("gitlib: cat-file --batch returned invalid size %q: %s", fields[2], err)
+	}
+	return fields[1], size, nil
+}
+
+// batchObjectReader reads exactly Size bytes of an object's payload and, on
+// Close, consumes the trailing newline that terminates the object's framing
+// before releasing the batch for the next request.
+type batchObjectReader struct {
+	r      io.Reader
+	trail  *bufio.Reader
+	unlock func()
+	closed bool
+}
+
+func (r *batchObjectReader) Read(p []byte) (int, error) {
+	return r.r.Read(p)
+}
+
+func (r *batchObjectReader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	defer r.unlock()
+
+	if _, err := io.Copy(io.Discard, r.r); err != nil {
+		return err
+	}
+	if _, err := r.trail.Discard(1); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Close terminates the batch subprocesses by closing their stdin and waits
+// for them to exit. It reads nothing further from their stdout.
+func (b *CatFileBatch) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var errs []string
+	if err := b.stdin.Close(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := b.cmd.Wait(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := b.checkStdin.Close(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := b.checkCmd.Wait(); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package gitlib
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func mustHash(t *testing.T, s string) Hash {
+	t.Helper()
+	h, err := ParseHash(SHA1, s)
+	if err != nil {
+		t.Fatalf("ParseHash(%q): %v", s, err)
+	}
+	return h
+}
+
+func TestReadBatchHeader(t *testing.T) {
+	hash := mustHash(t, "d670460b4b4aece5915caf5c68d12f560a9fe3e4")
+
+	t.Run("valid", func(t *testing.T) {
+		r := bufio.NewReader(strings.NewReader("d670460b4b4aece5915caf5c68d12f560a9fe3e4 blob 11\n"))
+		objType, size, err := readBatchHeader(r, hash)
+		if err != nil {
+			t.Fatalf("readBatchHeader: %v", err)
+		}
+		if objType != "blob" || size != 11 {
+			t.Errorf("readBatchHeader = %q, %d; want \"blob\", 11", objType, size)
+		}
+	})
+
+	t.Run("wrong hash", func(t *testing.T) {
+		r := bufio.NewReader(strings.NewReader("0000000000000000000000000000000000000 blob 11\n"))
+		if _, _, err := readBatchHeader(r, hash); err == nil {
+			t.Error("readBatchHeader with mismatched hash = nil error; want error")
+		}
+	})
+
+	t.Run("malformed line", func(t *testing.T) {
+		r := bufio.NewReader(strings.NewReader("not enough fields\n"))
+		if _, _, err := readBatchHeader(r, hash); err == nil {
+			t.Error("readBatchHeader with malformed line = nil error; want error")
+		}
+	})
+
+	t.Run("invalid size", func(t *testing.T) {
+		r := bufio.NewReader(strings.NewReader("d670460b4b4aece5915caf5c68d12f560a9fe3e4 blob notanumber\n"))
+		if _, _, err := readBatchHeader(r, hash); err == nil {
+			t.Error("readBatchHeader with invalid size = nil error; want error")
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		r := bufio.NewReader(strings.NewReader("d670460b4b4aece5915caf5c68d12f560a9fe3e4 missing\n"))
+		if _, _, err := readBatchHeader(r, hash); err == nil {
+			t.Error("readBatchHeader with \"missing\" response = nil error; want error")
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package gitlib
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Sentinel errors a *GitError can be compared against with errors.Is. They
+// are matched against a command's stderr using the heuristics in
+// classifyStderr; a git failure that doesn't match any of them compares
+// equal to none of them.
+var (
+	ErrNotARepository = errors.New("gitlib: not a git repository")
+	ErrRefNotFound    = errors.New("gitlib: ref not found")
+	ErrObjectNotFound = errors.New("gitlib: object not found")
+	ErrAlreadyExists  = errors.New("gitlib: already exists")
+	ErrMergeConflict  = errors.New("gitlib: merge conflict")
+	ErrNonFastForward = errors.New("gitlib: non-fast-forward update rejected")
+)
+
+// stderrPatterns pairs each sentinel error with the substrings (matched
+// case-insensitively) that git's own messages use for that condition.
+var stderrPatterns = []struct {
+	err      error
+	patterns []string
+}{
+	{ErrNotARepository, []string{"not a git repository"}},
+	{ErrRefNotFound, []string{"did not match any", "unknown revision", "no such ref", "not a valid ref", "unable to resolve reference"}},
+	{ErrObjectNotFound, []string{"bad object", "could not find object", "unable to find"}},
+	{ErrAlreadyExists, []string{"already exists"}},
+	{ErrMergeConflict, []string{"merge conflict", "fix conflicts", "automatic merge failed"}},
+	{ErrNonFastForward, []string{"non-fast-forward", "not a fast forward", "fetch first"}},
+}
+
+// classifyStderr returns the sentinel error matching stderr, or nil if none
+// of the known heuristics apply.
+func classifyStderr(stderr string) error {
+	s := strings.ToLower(stderr)
+	for _, p := range stderrPatterns {
+		for _, pattern := range p.patterns {
+			if strings.Contains(s, pattern) {
+				return p.err
+			}
+		}
+	}
+	return nil
+}
+
+// GitError is returned whenever a git subprocess exits with an error. It
+// carries enough of the failure to let callers distinguish one kind of
+// failure from another instead of pattern-matching an opaque string.
+type GitError struct {
+	// Args is the argument vector passed to git, without the binary path.
+	Args []string
+	// ExitCode is the process exit code, or -1 if the process never ran.
+	ExitCode int
+	Stderr   string
+	Stdout   []byte
+	// Cause is the error returned by the os/exec call, usually an
+	// *exec.ExitError.
+	Cause error
+}
+
+func (e *GitError) Error() string {
+	args := strings.Join(e.Args, " ")
+	stderr := strings.TrimSpace(e.Stderr)
+	if stderr == "" {
+		return fmt.Sprintf("git %s: %s", args, e.Cause)
+	}
+	return fmt.Sprintf("git %s: %s", args, stderr)
+}
+
+func (e *GitError) Unwrap() error {
+	return e.Cause
+}
+
+// Is lets errors.Is(err, ErrRefNotFound) and friends work against a
+// *GitError by classifying its Stderr.
+func (e *GitError) Is(target error) bool {
+	return classifyStderr(e.Stderr) == target
+}
+
+// newGitError builds a *GitError for a failed invocation of git with the
+// given args (excluding the binary path itself).
+func newGitError(args []string, cause error, stderr string, stdout []byte) *GitError {
+	ge := &GitError{
+		Args:     args,
+		ExitCode: -1,
+		Stderr:   stderr,
+		Stdout:   stdout,
+		Cause:    cause,
+	}
+	var exitErr *exec.ExitError
+	if errors.As(cause, &exitErr) {
+		ge.ExitCode = exitErr.ExitCode()
+	}
+	return ge
+}
+
+// gitError builds a *GitError from a finished *exec.Cmd and its captured
+// stderr, dropping the leading binary path from cmd.Args. stdout is whatever
+// of the command's standard output was captured before it failed, or nil if
+// none was (e.g. cmd.Run was used, or stdout was streamed straight to a
+// caller-supplied io.Writer rather than buffered).
+func (g *Transaction) gitError(cmd *exec.Cmd, cause error, errBf fmt.Stringer, stdout []byte) *GitError {
+	var args []string
+	if len(cmd.Args) > 1 {
+		args = cmd.Args[1:]
+	}
+	return newGitError(args, cause, errBf.String(), stdout)
+}
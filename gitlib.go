@@ -2,6 +2,7 @@ package gitlib
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -9,7 +10,6 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
-	"sync"
 )
 
 // Git represents the git command
@@ -18,14 +18,30 @@ type Git struct {
 	Env        []string
 	Debug      bool
 	Dir        string
-	mu         *sync.Mutex
+	// HashAlgo is the object-format new repositories are initialized with.
+	// It defaults to SHA1 when left empty. For an already initialized
+	// repository, NewGit sets it to what DetectHashAlgo reports.
+	HashAlgo HashAlgo
+	// Backend performs the core object/ref operations also exposed as Git
+	// convenience methods of the same name (WriteHashObject, WriteTree,
+	// CommitTree, UpdateHeadsRef, ReadCatFile, LsFiles). It defaults to an
+	// ExecBackend forking this Git's BinaryPath; set it to a GoGitBackend (or
+	// your own Backend) to avoid the subprocess dependency. See
+	// NewGitWithBackend.
+	//
+	// The Transaction methods of the same name always fork BinaryPath
+	// directly and never consult Backend: ExecBackend's implementation opens
+	// its own Transaction, and calling that from inside one already open
+	// would deadlock on g.lock.
+	Backend Backend
+	lock    chan struct{}
 }
 
 // NewGit returns a new git repo and an error if the git command could not be found inside the path
 // the current environment is used for the git command
 func NewGit(dir string) (g *Git, err error) {
 	g = &Git{}
-	g.mu = &sync.Mutex{}
+	g.lock = make(chan struct{}, 1)
 	g.Dir, err = filepath.Abs(dir)
 	if err != nil {
 		return
@@ -37,9 +53,31 @@ func NewGit(dir string) (g *Git, err error) {
 	// GIT_OBJECT_DIRECTORY => may be FUSE FS or also ram, then we can backup via git push
 	// g.Env = append(g.Env, "GIT_DIR=.gitdb")
 	g.BinaryPath, err = exec.LookPath("git")
+	if err != nil {
+		return
+	}
+	g.Backend = NewExecBackend(g)
+
+	if g.IsInitialized() {
+		g.detectHashAlgoOnOpen()
+	}
 	return
 }
 
+// NewGitWithBackend is like NewGit but installs backend in place of the
+// default ExecBackend, e.g. a GoGitBackend to avoid depending on a git
+// binary being on PATH.
+func NewGitWithBackend(dir string, backend Backend) (g *Git, err error) {
+	g, err = NewGit(dir)
+	// A missing git binary is not fatal here: NewGit has already set up
+	// everything but BinaryPath (and hash algorithm detection) by then.
+	if err != nil && !errors.Is(err, exec.ErrNotFound) {
+		return nil, err
+	}
+	g.Backend = backend
+	return g, nil
+}
+
 func (g *Git) IsInitialized() bool {
 	dir := filepath.Join(g.Dir, ".git")
 	info, err := os.Stat(dir)
@@ -55,17 +93,48 @@ func (g *Git) IsInitialized() bool {
 	return true
 }
 
+// acquireLock locks g for exclusive use, blocking until it is free
+func (g *Git) acquireLock() {
+	g.lock <- struct{}{}
+}
+
+// acquireLockCtx locks g for exclusive use, returning ctx.Err() if ctx is
+// done before the lock could be acquired
+func (g *Git) acquireLockCtx(ctx context.Context) error {
+	select {
+	case g.lock <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (g *Git) releaseLock() {
+	<-g.lock
+}
+
 // run the given commands, preventing other commands to be run at the same time, stopping
 // at the first error and returning it
 func (g *Git) Transaction(cmds ...func(*Transaction) error) error {
+	return g.TransactionContext(context.Background(), cmds...)
+}
+
+// TransactionContext is like Transaction but aborts and returns ctx.Err() if ctx is
+// cancelled while waiting for the lock or in between commands
+func (g *Git) TransactionContext(ctx context.Context, cmds ...func(*Transaction) error) error {
 	// fmt.Println("starting transaction")
-	g.mu.Lock()
-	defer g.mu.Unlock()
+	if err := g.acquireLockCtx(ctx); err != nil {
+		return err
+	}
+	defer g.releaseLock()
 
 	tr := &Transaction{g}
 
 	var err error
 	for _, cmd := range cmds {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
 		if err = cmd(tr); err != nil {
 			return err
 		}
@@ -80,10 +149,15 @@ type Transaction struct {
 // Cmd returns the command for the given params and the given directory
 // using the path of the git binary and the existing environment variables
 func (g *Transaction) cmd(params ...string) (cmd *exec.Cmd, errBuffer *bytes.Buffer) {
+	return g.cmdCtx(context.Background(), params...)
+}
+
+// cmdCtx is like cmd but the returned *exec.Cmd is killed when ctx is done
+func (g *Transaction) cmdCtx(ctx context.Context, params ...string) (cmd *exec.Cmd, errBuffer *bytes.Buffer) {
 	if g.Debug {
 		fmt.Printf("\n%s %s\n", g.BinaryPath, strings.Join(params, " "))
 	}
-	cmd = exec.Command(g.BinaryPath, params...)
+	cmd = exec.CommandContext(ctx, g.BinaryPath, params...)
 	cmd.Env = g.Env
 	cmd.Dir = g.Dir
 	var errBf bytes.Buffer
@@ -92,10 +166,14 @@ func (g *Transaction) cmd(params ...string) (cmd *exec.Cmd, errBuffer *bytes.Buf
 }
 
 func (g *Transaction) RunCmd(params ...string) error {
-	cmd, errBf := g.cmd(params...)
+	return g.RunCmdCtx(context.Background(), params...)
+}
+
+func (g *Transaction) RunCmdCtx(ctx context.Context, params ...string) error {
+	cmd, errBf := g.cmdCtx(ctx, params...)
 	err := cmd.Run()
 	if err != nil {
-		return errors.New(errBf.String())
+		return g.gitError(cmd, err, errBf, nil)
 	}
 	return nil
 }
@@ -103,10 +181,14 @@ func (g *Transaction) RunCmd(params ...string) error {
 // Exec runs the given params and returns the combined output of stdout and stderr and
 // any errors
 func (g *Transaction) Exec(params ...string) ([]byte, error) {
-	cmd, errBf := g.cmd(params...)
+	return g.ExecCtx(context.Background(), params...)
+}
+
+func (g *Transaction) ExecCtx(ctx context.Context, params ...string) ([]byte, error) {
+	cmd, errBf := g.cmdCtx(ctx, params...)
 	out, err := cmd.Output()
 	if err != nil {
-		err = errors.New(errBf.String())
+		err = g.gitError(cmd, err, errBf, out)
 	}
 	return out, err
 }
@@ -114,13 +196,17 @@ func (g *Transaction) Exec(params ...string) ([]byte, error) {
 func (g *Transaction) returnString(cmd *exec.Cmd, errBuffer *bytes.Buffer) (string, error) {
 	out, err := cmd.Output()
 	if err != nil {
-		return "", errors.New(errBuffer.String())
+		return "", g.gitError(cmd, err, errBuffer, out)
 	}
 	return strings.TrimSpace(string(out)), nil
 }
 
 func (g *Transaction) Init() error {
-	_, err := g.Exec("init")
+	return g.InitCtx(context.Background())
+}
+
+func (g *Transaction) InitCtx(ctx context.Context) error {
+	_, err := g.ExecCtx(ctx, "init", "--object-format="+string(g.hashAlgo()))
 	return err
 }
 
@@ -130,7 +216,7 @@ func (g *Transaction) InitBare() error {
 	return err
 }
 
-//  git ls-files 'node/pools/a63/84389-70d7-4199-9d90-4b8b9ba8e3d6'
+// git ls-files 'node/pools/a63/84389-70d7-4199-9d90-4b8b9ba8e3d6'
 func (t *Transaction) IsFileKnown(filepath string) (bool, error) {
 	// fmt.Println("checking for known file of path", filepath)
 	files, err := t.LsFiles(filepath)
@@ -144,11 +230,17 @@ func (t *Transaction) IsFileKnown(filepath string) (bool, error) {
 }
 
 // WriteHashObject writes the content of the given reader to the repository inside the given
-// directory. It returns the sha1 hash on success and an error otherwise
-func (g *Transaction) WriteHashObject(rd io.Reader) (string, error) {
-	cmd, errBf := g.cmd("hash-object", "-w", "--stdin")
+// directory. It returns the object hash on success and an error otherwise
+func (g *Transaction) WriteHashObject(rd io.Reader) (Hash, error) {
+	return g.WriteHashObjectCtx(context.Background(), rd)
+}
+
+// WriteHashObjectCtx is like WriteHashObject but the hash-object process is killed when ctx is done,
+// which matters for large blobs read from slow readers
+func (g *Transaction) WriteHashObjectCtx(ctx context.Context, rd io.Reader) (Hash, error) {
+	cmd, errBf := g.cmdCtx(ctx, "hash-object", "-w", "--stdin")
 	cmd.Stdin = rd
-	return g.returnString(cmd, errBf)
+	return g.returnHash(cmd, errBf)
 }
 
 func (t *Transaction) ResetToHead(path string) error {
@@ -161,10 +253,14 @@ func (t *Transaction) ResetToHeadAll() error {
 }
 
 // WriteHashObjectFile writes the content of the given file to the repository inside the given
-// directory. It returns the sha1 hash on success and an error otherwise
-func (g *Transaction) WriteHashObjectFile(filePath string) (string, error) {
-	cmd, errBf := g.cmd("hash-object", "-w", filePath)
-	return g.returnString(cmd, errBf)
+// directory. It returns the object hash on success and an error otherwise
+func (g *Transaction) WriteHashObjectFile(filePath string) (Hash, error) {
+	return g.WriteHashObjectFileCtx(context.Background(), filePath)
+}
+
+func (g *Transaction) WriteHashObjectFileCtx(ctx context.Context, filePath string) (Hash, error) {
+	cmd, errBf := g.cmdCtx(ctx, "hash-object", "-w", filePath)
+	return g.returnHash(cmd, errBf)
 }
 
 // git ls-files 'node/a63/84389-70d7-4199-9d90-4b8b9ba8e3d6'
@@ -186,11 +282,17 @@ func (t *Transaction) LsFiles(wildcard string) ([]string, error) {
 
 // ReadCatFile reads the object with the given sha1 and writes it to the given writer
 func (g *Transaction) ReadCatFile(sha1 string, wr io.Writer) error {
-	cmd, errBf := g.cmd("cat-file", "-p", sha1)
+	return g.ReadCatFileCtx(context.Background(), sha1, wr)
+}
+
+// ReadCatFileCtx is like ReadCatFile but the cat-file process is killed when ctx is done,
+// which matters when wr is slow or the object is large
+func (g *Transaction) ReadCatFileCtx(ctx context.Context, sha1 string, wr io.Writer) error {
+	cmd, errBf := g.cmdCtx(ctx, "cat-file", "-p", sha1)
 	cmd.Stdout = wr
 	err := cmd.Run()
 	if err != nil {
-		return errors.New(errBf.String())
+		return g.gitError(cmd, err, errBf, nil)
 	}
 	return nil
 }
@@ -205,19 +307,27 @@ func (g *Transaction) ReadCatHeadFile(path string, wr io.Writer) error {
 	return g.ReadCatFile("HEAD:"+path, wr)
 }
 
-// CatFileType returns the type of the object with the given sha1
-func (g *Transaction) CatFileType(sha1 string) (string, error) {
-	cmd, errBf := g.cmd("cat-file", "-t", sha1)
+// CatFileType returns the type of the object with the given hash
+func (g *Transaction) CatFileType(hash Hash) (string, error) {
+	return g.CatFileTypeCtx(context.Background(), hash)
+}
+
+func (g *Transaction) CatFileTypeCtx(ctx context.Context, hash Hash) (string, error) {
+	cmd, errBf := g.cmdCtx(ctx, "cat-file", "-t", hash.String())
 	return g.returnString(cmd, errBf)
 }
 
 // CatFileTree reads the tree of the last commit on branch to the given writer
 func (g *Transaction) ReadCatFileTree(branch string, wr io.Writer) error {
-	cmd, errBf := g.cmd("cat-file", "-p", branch+"^{tree}")
+	return g.ReadCatFileTreeCtx(context.Background(), branch, wr)
+}
+
+func (g *Transaction) ReadCatFileTreeCtx(ctx context.Context, branch string, wr io.Writer) error {
+	cmd, errBf := g.cmdCtx(ctx, "cat-file", "-p", branch+"^{tree}")
 	cmd.Stdout = wr
 	err := cmd.Run()
 	if err != nil {
-		return errors.New(errBf.String())
+		return g.gitError(cmd, err, errBf, nil)
 	}
 	return nil
 }
@@ -230,76 +340,100 @@ which specifies a symbolic link
 */
 
 // UpdateIndexFile updates the index of the given file with the data of the given
-// sha1
-func (g *Transaction) UpdateIndexCache(sha1, filepath string) error {
-	return g.RunCmd("update-index", "--cacheinfo", "100644", sha1, filepath)
+// hash
+func (g *Transaction) UpdateIndexCache(hash Hash, filepath string) error {
+	return g.RunCmd("update-index", "--cacheinfo", "100644", hash.String(), filepath)
 }
 
-func (g *Transaction) UpdateIndexCacheExecutable(sha1, filepath string) error {
-	return g.RunCmd("update-index", "--cacheinfo", "100755", sha1, filepath)
+func (g *Transaction) UpdateIndexCacheExecutable(hash Hash, filepath string) error {
+	return g.RunCmd("update-index", "--cacheinfo", "100755", hash.String(), filepath)
 }
 
-func (g *Transaction) UpdateIndexCacheLink(sha1, filepath string) error {
-	return g.RunCmd("update-index", "--cacheinfo", "120000", sha1, filepath)
+func (g *Transaction) UpdateIndexCacheLink(hash Hash, filepath string) error {
+	return g.RunCmd("update-index", "--cacheinfo", "120000", hash.String(), filepath)
 }
 
-//  git update-index --force-remove hu
+// git update-index --force-remove hu
 func (g *Transaction) RemoveIndex(filepath string) error {
 	return g.RunCmd("update-index", "--force-remove", filepath)
 }
 
-func (g *Transaction) AddIndexCache(sha1, filepath string) error {
-	return g.RunCmd("update-index", "--add", "--cacheinfo", "100644", sha1, filepath)
+func (g *Transaction) AddIndexCache(hash Hash, filepath string) error {
+	return g.RunCmd("update-index", "--add", "--cacheinfo", "100644", hash.String(), filepath)
 }
 
-func (g *Transaction) AddIndexCacheExecutable(sha1, filepath string) error {
-	return g.RunCmd("update-index", "--add", "--cacheinfo", "100755", sha1, filepath)
+func (g *Transaction) AddIndexCacheExecutable(hash Hash, filepath string) error {
+	return g.RunCmd("update-index", "--add", "--cacheinfo", "100755", hash.String(), filepath)
 }
 
-func (g *Transaction) AddIndexCacheLink(sha1, filepath string) error {
-	return g.RunCmd("update-index", "--add", "--cacheinfo", "120000", sha1, filepath)
+func (g *Transaction) AddIndexCacheLink(hash Hash, filepath string) error {
+	return g.RunCmd("update-index", "--add", "--cacheinfo", "120000", hash.String(), filepath)
 }
 
 // WriteTree writes the index to a tree
-func (g *Transaction) WriteTree() (string, error) {
-	cmd, errBf := g.cmd("write-tree")
-	return g.returnString(cmd, errBf)
+func (g *Transaction) WriteTree() (Hash, error) {
+	return g.WriteTreeCtx(context.Background())
+}
+
+func (g *Transaction) WriteTreeCtx(ctx context.Context) (Hash, error) {
+	cmd, errBf := g.cmdCtx(ctx, "write-tree")
+	return g.returnHash(cmd, errBf)
 }
 
 // git read-tree --prefix=bak d8329fc1cc938780ffdd9f94e0d364e0ea74f579
-func (g *Transaction) ReadTree(prefix, sha1 string) error {
-	return g.RunCmd("read-tree", "--prefix="+prefix, sha1)
+func (g *Transaction) ReadTree(prefix string, hash Hash) error {
+	return g.RunCmd("read-tree", "--prefix="+prefix, hash.String())
 }
 
 // git commit-tree d8329f
-func (g *Transaction) CommitTree(sha1, parent string, message io.Reader) (string, error) {
-	// fmt.Printf("committing: %#v with parent %#v\n", sha1, parent)
-	params := []string{"commit-tree", sha1}
-	if parent != "" {
-		params = append(params, "-p", parent)
+func (g *Transaction) CommitTree(hash, parent Hash, message io.Reader) (Hash, error) {
+	return g.CommitTreeCtx(context.Background(), hash, parent, message)
+}
+
+func (g *Transaction) CommitTreeCtx(ctx context.Context, hash, parent Hash, message io.Reader) (Hash, error) {
+	// fmt.Printf("committing: %#v with parent %#v\n", hash, parent)
+	params := []string{"commit-tree", hash.String()}
+	if !parent.IsZero() {
+		params = append(params, "-p", parent.String())
 	}
-	cmd, errBf := g.cmd(params...)
+	cmd, errBf := g.cmdCtx(ctx, params...)
 	cmd.Stdin = message
-	return g.returnString(cmd, errBf)
+	return g.returnHash(cmd, errBf)
 }
 
 func (g *Transaction) Commit(message string) error {
 	return g.RunCmd("commit", "-m", message)
 }
 
-func (g *Transaction) ShowHeadsRef(ref string) (string, error) {
+func (g *Transaction) ShowHeadsRef(ref string) (Hash, error) {
+	return g.ShowHeadsRefCtx(context.Background(), ref)
+}
+
+func (g *Transaction) ShowHeadsRefCtx(ctx context.Context, ref string) (Hash, error) {
+	ref, err := SanitizeRefName(ref)
+	if err != nil {
+		return nil, err
+	}
 	// git show-ref --hash --heads refs/heads/master
-	cmd, errBf := g.cmd("show-ref", "--hash", "--heads", "refs/heads/"+ref)
-	return g.returnString(cmd, errBf)
+	cmd, errBf := g.cmdCtx(ctx, "show-ref", "--hash", "--heads", "refs/heads/"+ref)
+	return g.returnHash(cmd, errBf)
 }
 
 // git update-ref refs/heads/master 1a410efbd13591db07496601ebc7a059dd55cfe9
-func (g *Transaction) UpdateHeadsRef(ref, sha1 string) error {
-	return g.RunCmd("update-ref", "refs/heads/"+ref, sha1)
+func (g *Transaction) UpdateHeadsRef(ref string, hash Hash) error {
+	ref, err := SanitizeRefName(ref)
+	if err != nil {
+		return err
+	}
+	return g.RunCmd("update-ref", "refs/heads/"+ref, hash.String())
 }
 
-func (g *Transaction) UpdateTagsRef(ref, sha1 string) error {
-	return g.RunCmd("update-ref", "refs/tags/"+ref, sha1)
+func (g *Transaction) UpdateTagsRef(ref string, hash Hash) error {
+	ref, err := SanitizeRefName(ref)
+	if err != nil {
+		return err
+	}
+	return g.RunCmd("update-ref", "refs/tags/"+ref, hash.String())
 }
 
 // git symbolic-ref HEAD
@@ -318,16 +452,24 @@ func (g *Transaction) SetSymbolicTagsRef(symref, tagsRef string) error {
 }
 
 func (g *Transaction) PushTags() error {
-	return g.RunCmd("push", "--tags", "-q")
+	return g.PushTagsCtx(context.Background())
+}
+
+func (g *Transaction) PushTagsCtx(ctx context.Context) error {
+	return g.RunCmdCtx(ctx, "push", "--tags", "-q")
 }
 
 func (g *Transaction) PushAll() error {
-	return g.RunCmd("push", "--all", "-q")
+	return g.PushAllCtx(context.Background())
+}
+
+func (g *Transaction) PushAllCtx(ctx context.Context) error {
+	return g.RunCmdCtx(ctx, "push", "--all", "-q")
 }
 
 // git tag -a v1.1 1a410efbd13591db07496601ebc7a059dd55cfe9 -m 'test tag'
-func (g *Transaction) Tag(tag, sha1, message string) error {
-	params := []string{"tag", tag, sha1}
+func (g *Transaction) Tag(tag string, hash Hash, message string) error {
+	params := []string{"tag", tag, hash.String()}
 	if message != "" {
 		params = append(params, "-a", "-m", message)
 	}
@@ -348,19 +490,33 @@ func (g *Transaction) Tags() ([]string, error) {
 
 // git gc --auto
 func (g *Transaction) GC() error {
-	return g.RunCmd("gc", "--auto")
+	return g.GCCtx(context.Background())
+}
+
+func (g *Transaction) GCCtx(ctx context.Context) error {
+	return g.RunCmdCtx(ctx, "gc", "--auto")
 }
 
 func (g *Transaction) Fsck() error {
-	return g.RunCmd("fsck")
+	return g.FsckCtx(context.Background())
+}
+
+func (g *Transaction) FsckCtx(ctx context.Context) error {
+	return g.RunCmdCtx(ctx, "fsck")
 }
 
 func (g *Transaction) FsckFull(wr io.Writer) error {
-	cmd, errBf := g.cmd("fsck", "--full")
+	return g.FsckFullCtx(context.Background(), wr)
+}
+
+// FsckFullCtx is like FsckFull but the fsck process is killed when ctx is done,
+// which matters since a full fsck can run for a long time on large repositories
+func (g *Transaction) FsckFullCtx(ctx context.Context, wr io.Writer) error {
+	cmd, errBf := g.cmdCtx(ctx, "fsck", "--full")
 	cmd.Stdout = wr
 	err := cmd.Run()
 	if err != nil {
-		return errors.New(errBf.String())
+		return g.gitError(cmd, err, errBf, nil)
 	}
 	return nil
 }
@@ -0,0 +1,242 @@
+//go:build gogit
+
+package gitlib
+
+// GoGitBackend requires github.com/go-git/go-git/v5 (go get it and build
+// with `-tags gogit`); it is kept behind a build tag so the rest of this
+// package has no hard dependency on it.
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// GoGitBackend is a Backend implemented on top of go-git: it reads and
+// writes a repository's object/ref storage directly, without forking a git
+// binary or requiring one to be on PATH.
+type GoGitBackend struct {
+	repo *gogit.Repository
+}
+
+// NewGoGitBackend opens the repository at dir with go-git and returns a
+// Backend for it. Use it with NewGitWithBackend.
+func NewGoGitBackend(dir string) (*GoGitBackend, error) {
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		return nil, fmt.Errorf("gitlib: go-git: %w", err)
+	}
+	return &GoGitBackend{repo: repo}, nil
+}
+
+func (b *GoGitBackend) WriteHashObject(rd io.Reader) (Hash, error) {
+	obj := b.repo.Storer.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+
+	wr, err := obj.Writer()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(wr, rd); err != nil {
+		wr.Close()
+		return nil, err
+	}
+	if err := wr.Close(); err != nil {
+		return nil, err
+	}
+
+	id, err := b.repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		return nil, err
+	}
+	return Hash(id[:]), nil
+}
+
+// indexTreeNode groups the index entries and subdirectories found directly
+// under one directory, keyed by their base name, so writeTreeFromIndex can
+// build nested tree objects bottom-up from the flat index.
+type indexTreeNode struct {
+	entries map[string]*index.Entry
+	dirs    map[string]*indexTreeNode
+}
+
+func newIndexTreeNode() *indexTreeNode {
+	return &indexTreeNode{entries: map[string]*index.Entry{}, dirs: map[string]*indexTreeNode{}}
+}
+
+// WriteTree turns the repository's current index into a tree object, the
+// same way `git write-tree` does, by grouping index entries into a
+// directory tree and encoding one object.Tree per directory, deepest first.
+func (b *GoGitBackend) WriteTree() (Hash, error) {
+	idx, err := b.repo.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	root := newIndexTreeNode()
+	for _, e := range idx.Entries {
+		parts := strings.Split(e.Name, "/")
+		dir := root
+		for _, p := range parts[:len(parts)-1] {
+			child, ok := dir.dirs[p]
+			if !ok {
+				child = newIndexTreeNode()
+				dir.dirs[p] = child
+			}
+			dir = child
+		}
+		dir.entries[parts[len(parts)-1]] = e
+	}
+
+	return b.writeTreeNode(root)
+}
+
+func (b *GoGitBackend) writeTreeNode(n *indexTreeNode) (Hash, error) {
+	tree := &object.Tree{}
+
+	for name, e := range n.entries {
+		tree.Entries = append(tree.Entries, object.TreeEntry{
+			Name: name,
+			Mode: e.Mode,
+			Hash: e.Hash,
+		})
+	}
+	for name, child := range n.dirs {
+		hash, err := b.writeTreeNode(child)
+		if err != nil {
+			return nil, err
+		}
+		tree.Entries = append(tree.Entries, object.TreeEntry{
+			Name: name,
+			Mode: filemode.Dir,
+			Hash: plumbing.NewHash(hash.String()),
+		})
+	}
+	// git orders a subtree as if its name ended in '/'
+	sortKey := func(e object.TreeEntry) string {
+		if e.Mode == filemode.Dir {
+			return e.Name + "/"
+		}
+		return e.Name
+	}
+	sort.Slice(tree.Entries, func(i, j int) bool { return sortKey(tree.Entries[i]) < sortKey(tree.Entries[j]) })
+
+	obj := b.repo.Storer.NewEncodedObject()
+	if err := tree.Encode(obj); err != nil {
+		return nil, err
+	}
+	id, err := b.repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		return nil, err
+	}
+	return Hash(id[:]), nil
+}
+
+func (b *GoGitBackend) CommitTree(tree, parent Hash, message io.Reader) (Hash, error) {
+	msg, err := io.ReadAll(message)
+	if err != nil {
+		return nil, err
+	}
+
+	sig := commitSignature()
+	commit := &object.Commit{
+		TreeHash:  plumbing.NewHash(tree.String()),
+		Message:   string(msg),
+		Author:    sig,
+		Committer: sig,
+	}
+	if !parent.IsZero() {
+		commit.ParentHashes = []plumbing.Hash{plumbing.NewHash(parent.String())}
+	}
+
+	obj := b.repo.Storer.NewEncodedObject()
+	if err := commit.Encode(obj); err != nil {
+		return nil, err
+	}
+	id, err := b.repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		return nil, err
+	}
+	return Hash(id[:]), nil
+}
+
+// commitSignature builds the Author/Committer signature for CommitTree from
+// the same GIT_AUTHOR_*/GIT_COMMITTER_* environment variables the git binary
+// itself honors, since go-git has no repository-level identity config of its
+// own to fall back to.
+func commitSignature() object.Signature {
+	name := os.Getenv("GIT_AUTHOR_NAME")
+	if name == "" {
+		name = os.Getenv("GIT_COMMITTER_NAME")
+	}
+	email := os.Getenv("GIT_AUTHOR_EMAIL")
+	if email == "" {
+		email = os.Getenv("GIT_COMMITTER_EMAIL")
+	}
+	return object.Signature{Name: name, Email: email, When: time.Now()}
+}
+
+func (b *GoGitBackend) UpdateHeadsRef(ref string, hash Hash) error {
+	ref, err := SanitizeRefName(ref)
+	if err != nil {
+		return err
+	}
+	r := plumbing.NewHashReference(plumbing.NewBranchReferenceName(ref), plumbing.NewHash(hash.String()))
+	return b.repo.Storer.SetReference(r)
+}
+
+// ReadCatFile writes hash's raw blob content to wr. Only blobs are
+// supported: see the Backend.ReadCatFile doc for why a tree, commit or tag
+// hash returns an error here instead of this silently returning different
+// bytes than ExecBackend.ReadCatFile would for the same hash.
+func (b *GoGitBackend) ReadCatFile(hash Hash, wr io.Writer) error {
+	obj, err := b.repo.Storer.EncodedObject(plumbing.AnyObject, plumbing.NewHash(hash.String()))
+	if err != nil {
+		return err
+	}
+	if obj.Type() != plumbing.BlobObject {
+		return fmt.Errorf("gitlib: GoGitBackend.ReadCatFile: only blob objects are supported, got %s", obj.Type())
+	}
+	rd, err := obj.Reader()
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+	_, err = io.Copy(wr, rd)
+	return err
+}
+
+// LsFiles matches wildcard, git-style, against the paths recorded in the
+// repository's index - the same source `git ls-files` (what
+// ExecBackend.LsFiles runs) reads from - using filepath.Match as an
+// approximation of git's fnmatch-based wildcard semantics. A worktree's
+// Status() is the wrong source: it reports only files that differ from HEAD
+// plus untracked files, omitting every clean tracked file.
+func (b *GoGitBackend) LsFiles(wildcard string) ([]string, error) {
+	idx, err := b.repo.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []string
+	for _, e := range idx.Entries {
+		ok, err := filepath.Match(wildcard, e.Name)
+		if err != nil {
+			return nil, err
+		}
+		if ok || wildcard == e.Name {
+			matches = append(matches, e.Name)
+		}
+	}
+	return matches, nil
+}
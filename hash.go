@@ -0,0 +1,114 @@
+package gitlib
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// HashAlgo identifies the object hash function a repository was initialized
+// with. Git repositories created before the object-format option existed are
+// implicitly SHA1.
+type HashAlgo string
+
+const (
+	SHA1   HashAlgo = "sha1"
+	SHA256 HashAlgo = "sha256"
+)
+
+// hexLen returns the number of hex characters a Hash produced by a is encoded as
+func (a HashAlgo) hexLen() int {
+	if a == SHA256 {
+		return 64
+	}
+	return 40
+}
+
+// Hash is a git object id, decoded from its hex representation. Its length
+// depends on the HashAlgo of the repository that produced it: 20 bytes for
+// sha1, 32 bytes for sha256.
+type Hash []byte
+
+// ParseHash decodes the hex string s as a Hash produced by algo, returning an
+// error if s is not valid hex or does not have the length algo expects.
+func ParseHash(algo HashAlgo, s string) (Hash, error) {
+	s = strings.TrimSpace(s)
+	if len(s) != algo.hexLen() {
+		return nil, fmt.Errorf("gitlib: invalid %s hash %q: expected %d hex chars, got %d", algo, s, algo.hexLen(), len(s))
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("gitlib: invalid %s hash %q: %s", algo, s, err)
+	}
+	return Hash(b), nil
+}
+
+// String returns the lowercase hex representation of h
+func (h Hash) String() string {
+	return hex.EncodeToString(h)
+}
+
+// Equal reports whether h and other represent the same object id
+func (h Hash) Equal(other Hash) bool {
+	return bytes.Equal(h, other)
+}
+
+// IsZero reports whether h carries no object id, e.g. a commit with no parent
+func (h Hash) IsZero() bool {
+	return len(h) == 0
+}
+
+// hashAlgo returns the HashAlgo to operate with, defaulting to SHA1 for
+// repositories that never had one configured explicitly
+func (g *Git) hashAlgo() HashAlgo {
+	if g.HashAlgo == "" {
+		return SHA1
+	}
+	return g.HashAlgo
+}
+
+// DetectHashAlgo returns the object format (hash algorithm) of the already
+// initialized repository, as reported by git itself. Use it to pick the
+// right HashAlgo when opening a repository that was not created via Init.
+func (g *Transaction) DetectHashAlgo() (HashAlgo, error) {
+	return g.DetectHashAlgoCtx(context.Background())
+}
+
+func (g *Transaction) DetectHashAlgoCtx(ctx context.Context) (HashAlgo, error) {
+	cmd, errBf := g.cmdCtx(ctx, "rev-parse", "--show-object-format")
+	out, err := g.returnString(cmd, errBf)
+	if err != nil {
+		return "", err
+	}
+	return HashAlgo(out), nil
+}
+
+// detectHashAlgoOnOpen is called by NewGit for an already-initialized
+// repository so g.HashAlgo reflects what the repository was actually
+// created with, not just the SHA1 hashAlgo default, without every caller
+// having to remember to call DetectHashAlgo itself. Detection failure (e.g.
+// a git older than the one that introduced --show-object-format) is not
+// fatal to opening the repository: g.HashAlgo is simply left at its
+// zero value, which hashAlgo treats as SHA1, same as before this existed.
+func (g *Git) detectHashAlgoOnOpen() {
+	_ = g.Transaction(func(tr *Transaction) error {
+		algo, err := tr.DetectHashAlgo()
+		if err != nil {
+			return err
+		}
+		g.HashAlgo = algo
+		return nil
+	})
+}
+
+// returnHash runs cmd and parses its trimmed stdout as a Hash for g's HashAlgo
+func (g *Transaction) returnHash(cmd *exec.Cmd, errBf *bytes.Buffer) (Hash, error) {
+	s, err := g.returnString(cmd, errBf)
+	if err != nil {
+		return nil, err
+	}
+	return ParseHash(g.hashAlgo(), s)
+}
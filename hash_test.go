@@ -0,0 +1,29 @@
+package gitlib
+
+import "testing"
+
+func TestNewGitDetectsHashAlgo(t *testing.T) {
+	requireGitBinary(t)
+	dir, _ := initTestRepo(t)
+
+	g, err := NewGit(dir)
+	if err != nil {
+		t.Fatalf("NewGit: %v", err)
+	}
+	if g.HashAlgo != SHA1 {
+		t.Errorf("g.HashAlgo = %q; want %q", g.HashAlgo, SHA1)
+	}
+}
+
+func TestNewGitUninitializedLeavesHashAlgoEmpty(t *testing.T) {
+	requireGitBinary(t)
+	dir := t.TempDir()
+
+	g, err := NewGit(dir)
+	if err != nil {
+		t.Fatalf("NewGit: %v", err)
+	}
+	if g.HashAlgo != "" {
+		t.Errorf("g.HashAlgo = %q; want empty for an uninitialized dir", g.HashAlgo)
+	}
+}
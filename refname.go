@@ -0,0 +1,199 @@
+package gitlib
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidRefName is returned by SanitizeRefName when path violates one of
+// the construction rules git enforces on ref names (see git's refs.c /
+// check_refname_format).
+var ErrInvalidRefName = errors.New("gitlib: invalid ref name")
+
+// refNameError pinpoints which rule SanitizeRefName rejected path for. It
+// wraps ErrInvalidRefName so callers can match on it with errors.Is.
+type refNameError struct {
+	path string
+	rule string
+}
+
+func (e *refNameError) Error() string {
+	return fmt.Sprintf("gitlib: invalid ref name %q: %s", e.path, e.rule)
+}
+
+func (e *refNameError) Unwrap() error {
+	return ErrInvalidRefName
+}
+
+// SanitizeRefName validates path as a single ref name component (the part
+// after "refs/heads/" or "refs/tags/"), rejecting everything git's own
+// refs.c rules forbid:
+//
+//   - an empty component
+//   - ".." anywhere
+//   - a component starting with "."
+//   - a component ending in "." or ".lock"
+//   - ASCII control characters (bytes < 0x20 or 0x7f)
+//   - any of: space ~ ^ : ? * [ \
+//   - consecutive slashes, or a leading/trailing slash
+//   - the sequence "@{"
+//   - a lone "@"
+//
+// It returns path unchanged if valid, or an error wrapping ErrInvalidRefName
+// otherwise.
+func SanitizeRefName(path string) (string, error) {
+	if path == "" {
+		return "", &refNameError{path, "must not be empty"}
+	}
+	if path == "@" {
+		return "", &refNameError{path, "must not be a lone @"}
+	}
+	if strings.Contains(path, "..") {
+		return "", &refNameError{path, "must not contain '..'"}
+	}
+	if strings.Contains(path, "@{") {
+		return "", &refNameError{path, "must not contain '@{'"}
+	}
+	if strings.HasPrefix(path, "/") || strings.HasSuffix(path, "/") {
+		return "", &refNameError{path, "must not start or end with '/'"}
+	}
+	if strings.Contains(path, "//") {
+		return "", &refNameError{path, "must not contain consecutive slashes"}
+	}
+	if strings.HasSuffix(path, ".lock") {
+		return "", &refNameError{path, "must not end in '.lock'"}
+	}
+	if strings.HasSuffix(path, ".") {
+		return "", &refNameError{path, "must not end in '.'"}
+	}
+
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" {
+			return "", &refNameError{path, "must not contain an empty path segment"}
+		}
+		if strings.HasPrefix(segment, ".") {
+			return "", &refNameError{path, "no path segment may start with '.'"}
+		}
+		if strings.HasSuffix(segment, ".lock") {
+			return "", &refNameError{path, "no path segment may end in '.lock'"}
+		}
+		if strings.HasSuffix(segment, ".") {
+			return "", &refNameError{path, "no path segment may end in '.'"}
+		}
+	}
+
+	for _, r := range path {
+		switch {
+		case r < 0x20 || r == 0x7f:
+			return "", &refNameError{path, "must not contain ASCII control characters"}
+		case strings.ContainsRune(" ~^:?*[\\", r):
+			return "", &refNameError{path, fmt.Sprintf("must not contain %q", r)}
+		}
+	}
+
+	return path, nil
+}
+
+// emptySegmentMarker stands in for a "/"-delimited segment that was empty -
+// the result of a leading, trailing or doubled slash in the original path.
+// escapeRefNameSegment only ever emits a '%' followed by exactly two lowercase
+// hex digits, so "%zz" - 'z' not being a hex digit - can never be the escaped
+// form of a real, non-empty segment, and so can never collide with one.
+const emptySegmentMarker = "%zz"
+
+// EscapeRefName percent-encodes path, segment by segment, so the result
+// always satisfies SanitizeRefName and - unlike a plain byte-blacklist
+// escape - can be decoded back to the original string with UnescapeRefName.
+// This makes it possible to round-trip arbitrary strings, such as
+// filesystem paths kept by a backup tool, through a ref name.
+//
+// Within each "/"-delimited segment, every byte other than
+// [A-Za-z0-9_-] is percent-encoded, including '.' itself; since a literal
+// '.' byte can then never appear in the output, none of ".", "..", a
+// leading '.' or a trailing ".lock" can occur. '@' is escaped too, which
+// rules out both "@{" and a lone "@". An empty segment - from a leading,
+// trailing or doubled slash - is replaced with emptySegmentMarker so the
+// original slash layout can be reconstructed on decode.
+func EscapeRefName(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = escapeRefNameSegment(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func escapeRefNameSegment(segment string) string {
+	if segment == "" {
+		return emptySegmentMarker
+	}
+
+	var b strings.Builder
+	b.Grow(len(segment))
+	for i := 0; i < len(segment); i++ {
+		c := segment[i]
+		if refNameByteIsSafe(c) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02x", c)
+	}
+	return b.String()
+}
+
+// UnescapeRefName reverses EscapeRefName, returning an error if escaped is
+// not one of its outputs (e.g. a malformed or truncated "%xx" sequence).
+func UnescapeRefName(escaped string) (string, error) {
+	segments := strings.Split(escaped, "/")
+	for i, segment := range segments {
+		if segment == emptySegmentMarker {
+			segments[i] = ""
+			continue
+		}
+		unescaped, err := unescapeRefNameSegment(segment)
+		if err != nil {
+			return "", err
+		}
+		segments[i] = unescaped
+	}
+	return strings.Join(segments, "/"), nil
+}
+
+func unescapeRefNameSegment(segment string) (string, error) {
+	var b strings.Builder
+	b.Grow(len(segment))
+	for i := 0; i < len(segment); i++ {
+		c := segment[i]
+		if c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+		if i+2 >= len(segment) {
+			return "", fmt.Errorf("gitlib: invalid percent-escape in ref name segment %q", segment)
+		}
+		v, err := strconv.ParseUint(segment[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("gitlib: invalid percent-escape in ref name segment %q: %s", segment, err)
+		}
+		b.WriteByte(byte(v))
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// refNameByteIsSafe reports whether c needs no escaping in an EscapeRefName
+// segment. Keeping the safe set to [A-Za-z0-9_-] - well inside what
+// SanitizeRefName allows - is what lets every forbidden multi-byte
+// construct (".." , a leading '.', ".lock", "@{", a lone '@') be ruled out
+// simply by making its component bytes unescapable.
+func refNameByteIsSafe(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	case c == '_' || c == '-':
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,121 @@
+package gitlib
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSanitizeRefName(t *testing.T) {
+	valid := []string{
+		"main",
+		"feature/foo",
+		"v1.2.3",
+		"a/b/c",
+		"foo-bar_baz",
+	}
+	for _, path := range valid {
+		if got, err := SanitizeRefName(path); err != nil {
+			t.Errorf("SanitizeRefName(%q) = %q, %v; want no error", path, got, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"@",
+		"a..b",
+		"a@{b",
+		"/a",
+		"a/",
+		"a//b",
+		"foo.lock",
+		"a/foo.lock",
+		".foo",
+		"a/.foo",
+		"a b",
+		"a~b",
+		"a^b",
+		"a:b",
+		"a?b",
+		"a*b",
+		"a[b",
+		"a\\b",
+		"a\x01b",
+		"a\x7fb",
+		"foo.",
+		"a/foo.",
+	}
+	for _, path := range invalid {
+		if _, err := SanitizeRefName(path); err == nil {
+			t.Errorf("SanitizeRefName(%q) = nil error; want error", path)
+		} else if !errors.Is(err, ErrInvalidRefName) {
+			t.Errorf("SanitizeRefName(%q) error %v does not wrap ErrInvalidRefName", path, err)
+		}
+	}
+}
+
+func TestEscapeRefNameRoundTrip(t *testing.T) {
+	paths := []string{
+		"main",
+		"a..b",
+		"foo.lock",
+		"a//b",
+		"foo/.bar",
+		"/a/b",
+		"a/b/",
+		"@",
+		"a@{b",
+		".hidden",
+		"weird chars: ~^:?*[\\",
+		"",
+		"a/b/c",
+		"\x00",
+		"a/\x00/b",
+		"foo.",
+		"a/foo.",
+	}
+	for _, path := range paths {
+		escaped := EscapeRefName(path)
+		if _, err := SanitizeRefName(escaped); err != nil {
+			t.Errorf("EscapeRefName(%q) = %q, which SanitizeRefName still rejects: %v", path, escaped, err)
+		}
+		got, err := UnescapeRefName(escaped)
+		if err != nil {
+			t.Errorf("UnescapeRefName(%q) failed: %v", escaped, err)
+			continue
+		}
+		if got != path {
+			t.Errorf("round-trip mismatch: EscapeRefName(%q) = %q, UnescapeRefName(...) = %q", path, escaped, got)
+		}
+	}
+}
+
+func TestUnescapeRefNameInvalid(t *testing.T) {
+	invalid := []string{
+		"%",
+		"%a",
+		"%gg",
+		"a%",
+	}
+	for _, escaped := range invalid {
+		if _, err := UnescapeRefName(escaped); err == nil {
+			t.Errorf("UnescapeRefName(%q) = nil error; want error", escaped)
+		}
+	}
+}
+
+// TestEscapeRefNameNoMarkerCollision guards against the emptySegmentMarker
+// colliding with the escaped form of a real segment - e.g. a single NUL byte
+// used to escape to "%00", the same marker used for an empty segment.
+func TestEscapeRefNameNoMarkerCollision(t *testing.T) {
+	escaped := escapeRefNameSegment("\x00")
+	if escaped == emptySegmentMarker {
+		t.Fatalf("escapeRefNameSegment(%q) = %q, which collides with emptySegmentMarker", "\x00", escaped)
+	}
+	got, err := UnescapeRefName(escaped)
+	if err != nil {
+		t.Fatalf("UnescapeRefName(%q) failed: %v", escaped, err)
+	}
+	if got != "\x00" {
+		t.Fatalf("round-trip mismatch: got %q, want %q", got, "\x00")
+	}
+}
@@ -0,0 +1,189 @@
+package gitlib
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TransactionAtomic is like Transaction but additionally snapshots the index
+// file and every refs/heads/* and refs/tags/* value (including HEAD) before
+// running cmds. If any command returns an error, the index and refs are
+// restored to their snapshotted state before the error is returned.
+//
+// Object writes are never rolled back: a command that wrote blobs, trees or
+// commits before failing leaves them in place. That's harmless - they
+// become unreachable once their ref update is undone, and are reaped by a
+// later gc - and rolling them back would need a second pass to find every
+// object a failed command wrote.
+func (g *Git) TransactionAtomic(cmds ...func(*Transaction) error) error {
+	return g.TransactionAtomicContext(context.Background(), cmds...)
+}
+
+// TransactionAtomicContext is TransactionAtomic with ctx cancellation, as
+// TransactionContext is to Transaction.
+func (g *Git) TransactionAtomicContext(ctx context.Context, cmds ...func(*Transaction) error) error {
+	if err := g.acquireLockCtx(ctx); err != nil {
+		return err
+	}
+	defer g.releaseLock()
+
+	tr := &Transaction{g}
+
+	snap, err := tr.snapshotRepo()
+	if err != nil {
+		return err
+	}
+	defer snap.cleanup()
+
+	for _, cmd := range cmds {
+		if err := ctx.Err(); err != nil {
+			if rerr := snap.restore(tr); rerr != nil {
+				return fmt.Errorf("gitlib: %s (rollback also failed: %s)", err, rerr)
+			}
+			return err
+		}
+		if err := cmd(tr); err != nil {
+			if rerr := snap.restore(tr); rerr != nil {
+				return fmt.Errorf("gitlib: %s (rollback also failed: %s)", err, rerr)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// repoSnapshot is what TransactionAtomic restores on failure: the index
+// file, the value of every refs/heads and refs/tags entry, and HEAD itself
+// (symbolic or detached).
+type repoSnapshot struct {
+	indexPath   string
+	indexBackup string
+	hadIndex    bool
+
+	refs map[string]string // "refs/heads/x" -> hex object id
+
+	headIsSymbolic bool
+	head           string // symbolic target, or hex object id when detached
+}
+
+func (tr *Transaction) snapshotRepo() (*repoSnapshot, error) {
+	s := &repoSnapshot{
+		indexPath: filepath.Join(tr.Dir, ".git", "index"),
+	}
+
+	data, err := os.ReadFile(s.indexPath)
+	switch {
+	case err == nil:
+		backup, err := os.CreateTemp("", "gitlib-index-*.bak")
+		if err != nil {
+			return nil, err
+		}
+		defer backup.Close()
+		if _, err := backup.Write(data); err != nil {
+			os.Remove(backup.Name())
+			return nil, err
+		}
+		s.indexBackup = backup.Name()
+		s.hadIndex = true
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+
+	refs, err := tr.forEachRef()
+	if err != nil {
+		return nil, err
+	}
+	s.refs = refs
+
+	if target, err := tr.GetSymbolicRef("HEAD"); err == nil {
+		s.headIsSymbolic = true
+		s.head = target
+	} else {
+		cmd, errBf := tr.cmd("rev-parse", "HEAD")
+		if head, err := tr.returnString(cmd, errBf); err == nil {
+			s.head = head
+		}
+	}
+
+	return s, nil
+}
+
+// restore undoes everything cmds may have changed since the snapshot: refs
+// that did not exist before are deleted, refs that changed are reset, HEAD
+// is pointed back where it was, and the index file is put back.
+func (s *repoSnapshot) restore(tr *Transaction) error {
+	current, err := tr.forEachRef()
+	if err != nil {
+		return err
+	}
+
+	for name := range current {
+		if _, existed := s.refs[name]; !existed {
+			if err := tr.RunCmd("update-ref", "-d", name); err != nil {
+				return err
+			}
+		}
+	}
+	for name, hash := range s.refs {
+		if hash != current[name] {
+			if err := tr.RunCmd("update-ref", name, hash); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch {
+	case s.headIsSymbolic:
+		if err := tr.RunCmd("symbolic-ref", "HEAD", s.head); err != nil {
+			return err
+		}
+	case s.head != "":
+		if err := tr.RunCmd("update-ref", "--no-deref", "HEAD", s.head); err != nil {
+			return err
+		}
+	}
+
+	if s.hadIndex {
+		data, err := os.ReadFile(s.indexBackup)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(s.indexPath, data, 0600)
+	}
+	if err := os.Remove(s.indexPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *repoSnapshot) cleanup() {
+	if s.indexBackup != "" {
+		os.Remove(s.indexBackup)
+	}
+}
+
+// forEachRef returns every refs/heads/* and refs/tags/* ref and the hex
+// object id it currently points to.
+func (tr *Transaction) forEachRef() (map[string]string, error) {
+	cmd, errBf := tr.cmd("for-each-ref", "--format=%(refname) %(objectname)", "refs/heads", "refs/tags")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, tr.gitError(cmd, err, errBf, out)
+	}
+
+	refs := map[string]string{}
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		refs[fields[0]] = fields[1]
+	}
+	return refs, sc.Err()
+}
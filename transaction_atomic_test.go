@@ -0,0 +1,127 @@
+package gitlib
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func requireGitBinary(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not found in PATH")
+	}
+}
+
+var testGitEnv = append(os.Environ(),
+	"GIT_AUTHOR_NAME=gitlib-test", "GIT_AUTHOR_EMAIL=gitlib-test@example.com",
+	"GIT_COMMITTER_NAME=gitlib-test", "GIT_COMMITTER_EMAIL=gitlib-test@example.com",
+)
+
+// initTestRepo creates a repository in a fresh temp dir with one commit on
+// its default branch and returns the dir and that commit's hash.
+func initTestRepo(t *testing.T) (dir, headHash string) {
+	t.Helper()
+	dir = t.TempDir()
+
+	run := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = testGitEnv
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("git %s: %v", strings.Join(args, " "), err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	run("init", "-q")
+	if err := os.WriteFile(filepath.Join(dir, "file.txt"), []byte("hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run("add", "file.txt")
+	run("commit", "-q", "-m", "initial")
+	return dir, run("rev-parse", "HEAD")
+}
+
+func TestTransactionAtomicRollsBackNewRef(t *testing.T) {
+	requireGitBinary(t)
+	dir, headHash := initTestRepo(t)
+
+	g, err := NewGit(dir)
+	if err != nil {
+		t.Fatalf("NewGit: %v", err)
+	}
+
+	wantErr := errors.New("boom")
+	err = g.TransactionAtomic(
+		func(tr *Transaction) error {
+			return tr.RunCmd("update-ref", "refs/heads/feature", headHash)
+		},
+		func(tr *Transaction) error {
+			return wantErr
+		},
+	)
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("TransactionAtomic error = %v; want %v", err, wantErr)
+	}
+
+	cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", "refs/heads/feature")
+	cmd.Dir = dir
+	if err := cmd.Run(); err == nil {
+		t.Error("refs/heads/feature still exists after rollback; want it gone")
+	}
+}
+
+func TestTransactionAtomicRollsBackChangedRefAndIndex(t *testing.T) {
+	requireGitBinary(t)
+	dir, headHash := initTestRepo(t)
+
+	g, err := NewGit(dir)
+	if err != nil {
+		t.Fatalf("NewGit: %v", err)
+	}
+	g.Env = testGitEnv
+
+	wantErr := errors.New("boom")
+	err = g.TransactionAtomic(
+		func(tr *Transaction) error {
+			if err := os.WriteFile(filepath.Join(dir, "file2.txt"), []byte("world\n"), 0644); err != nil {
+				return err
+			}
+			if err := tr.RunCmd("add", "file2.txt"); err != nil {
+				return err
+			}
+			return tr.RunCmd("commit", "-q", "-m", "second")
+		},
+		func(tr *Transaction) error {
+			return wantErr
+		},
+	)
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("TransactionAtomic error = %v; want %v", err, wantErr)
+	}
+
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git rev-parse HEAD: %v", err)
+	}
+	if got := strings.TrimSpace(string(out)); got != headHash {
+		t.Errorf("HEAD after rollback = %s; want %s", got, headHash)
+	}
+
+	cmd = exec.Command("git", "status", "--porcelain")
+	cmd.Dir = dir
+	out, err = cmd.Output()
+	if err != nil {
+		t.Fatalf("git status: %v", err)
+	}
+	if got := strings.TrimSpace(string(out)); got != "?? file2.txt" {
+		t.Errorf("git status after rollback = %q; want only file2.txt untracked (index restored, commit undone)", got)
+	}
+}
@@ -0,0 +1,305 @@
+package gitlib
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// WalkOptions configures WalkTree and RestoreTree.
+type WalkOptions struct {
+	// Workers is the number of goroutines fn (or, for RestoreTree, blob
+	// materialization) is dispatched across. Zero means runtime.NumCPU().
+	Workers int
+}
+
+func (o WalkOptions) workers() int {
+	if o.Workers > 0 {
+		return o.Workers
+	}
+	return runtime.NumCPU()
+}
+
+// git tree entry modes, as found in the binary tree object format
+const (
+	modeTree     uint32 = 0040000
+	modeBlob     uint32 = 0100644
+	modeBlobExec uint32 = 0100755
+	modeSymlink  uint32 = 0120000
+)
+
+type treeEntry struct {
+	mode uint32
+	name string
+	hash Hash
+}
+
+// WalkTree recursively resolves the tree at hash via a single `git cat-file
+// --batch` process and calls fn once for every blob or symlink entry found;
+// subtrees are descended into but never passed to fn. fn is dispatched
+// across opts.Workers goroutines; the first error, whether from resolving
+// the tree or from fn, cancels the remaining traversal and is returned.
+func (g *Git) WalkTree(hash Hash, fn func(path string, mode uint32, blob Hash) error, opts WalkOptions) error {
+	return g.WalkTreeCtx(context.Background(), hash, fn, opts)
+}
+
+// WalkTreeCtx is like WalkTree but ctx bounds the whole traversal: cancelling
+// it stops in-flight workers and the underlying cat-file process.
+func (g *Git) WalkTreeCtx(ctx context.Context, hash Hash, fn func(path string, mode uint32, blob Hash) error, opts WalkOptions) error {
+	var walkErr error
+	err := g.TransactionContext(ctx, func(tr *Transaction) error {
+		batch, err := tr.OpenCatFileBatchCtx(ctx)
+		if err != nil {
+			return err
+		}
+		defer batch.Close()
+
+		walkErr = walkTree(ctx, batch, hash, fn, opts)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return walkErr
+}
+
+// RestoreTree materializes every blob reachable from the tree at hash into
+// destDir, honoring executable (100755) and symlink (120000) modes. The tree
+// structure is resolved through one cat-file --batch process, but unlike
+// WalkTree - whose fn rarely talks to git at all - every RestoreTree worker
+// reads an actual blob, so each of opts.Workers goroutines gets its own
+// cat-file --batch process to read and write through; sharing a single
+// batch across workers would serialize every blob read on its lock and
+// leave Workers with no effect.
+func (g *Git) RestoreTree(hash Hash, destDir string, opts WalkOptions) error {
+	return g.RestoreTreeCtx(context.Background(), hash, destDir, opts)
+}
+
+func (g *Git) RestoreTreeCtx(ctx context.Context, hash Hash, destDir string, opts WalkOptions) error {
+	var walkErr error
+	err := g.TransactionContext(ctx, func(tr *Transaction) error {
+		treeBatch, err := tr.OpenCatFileBatchCtx(ctx)
+		if err != nil {
+			return err
+		}
+		defer treeBatch.Close()
+
+		blobBatches := make([]*CatFileBatch, 0, opts.workers())
+		defer func() {
+			for _, b := range blobBatches {
+				b.Close()
+			}
+		}()
+		for i := 0; i < opts.workers(); i++ {
+			b, err := tr.OpenCatFileBatchCtx(ctx)
+			if err != nil {
+				return err
+			}
+			blobBatches = append(blobBatches, b)
+		}
+
+		walkErr = restoreTreeParallel(ctx, treeBatch, blobBatches, hash, destDir)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return walkErr
+}
+
+// restoreTreeParallel resolves hash's tree structure sequentially over
+// treeBatch (from the calling goroutine) and dispatches every blob entry it
+// finds to one of len(blobBatches) workers, each reading and writing through
+// its own batch so the workers never contend with each other.
+func restoreTreeParallel(ctx context.Context, treeBatch *CatFileBatch, blobBatches []*CatFileBatch, hash Hash, destDir string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var once sync.Once
+	var firstErr error
+	setErr := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	jobs := make(chan treeEntry)
+	var wg sync.WaitGroup
+	for _, batch := range blobBatches {
+		wg.Add(1)
+		go func(batch *CatFileBatch) {
+			defer wg.Done()
+			for e := range jobs {
+				if err := restoreBlob(batch, destDir, e.name, e.mode, e.hash); err != nil {
+					setErr(err)
+				}
+			}
+		}(batch)
+	}
+
+	walkTreeRecursive(ctx, treeBatch, hash, "", jobs, setErr)
+	close(jobs)
+	wg.Wait()
+
+	return firstErr
+}
+
+// walkTree fans fn out across opts.Workers goroutines while resolving hash's
+// tree structure sequentially over batch (cat-file --batch only allows one
+// in-flight request at a time). The first error encountered, from either the
+// traversal or a worker, short-circuits the remaining work.
+func walkTree(ctx context.Context, batch *CatFileBatch, hash Hash, fn func(path string, mode uint32, blob Hash) error, opts WalkOptions) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var once sync.Once
+	var firstErr error
+	setErr := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	jobs := make(chan treeEntry)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.workers(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range jobs {
+				if err := fn(e.name, e.mode, e.hash); err != nil {
+					setErr(err)
+				}
+			}
+		}()
+	}
+
+	walkTreeRecursive(ctx, batch, hash, "", jobs, setErr)
+	close(jobs)
+	wg.Wait()
+
+	return firstErr
+}
+
+func walkTreeRecursive(ctx context.Context, batch *CatFileBatch, hash Hash, prefix string, jobs chan<- treeEntry, setErr func(error)) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	entries, err := readTreeEntries(batch, hash)
+	if err != nil {
+		setErr(err)
+		return
+	}
+
+	for _, e := range entries {
+		path := e.name
+		if prefix != "" {
+			path = prefix + "/" + e.name
+		}
+		if e.mode == modeTree {
+			walkTreeRecursive(ctx, batch, e.hash, path, jobs, setErr)
+			continue
+		}
+		select {
+		case jobs <- treeEntry{mode: e.mode, name: path, hash: e.hash}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// readTreeEntries reads and parses the tree object at hash through batch
+func readTreeEntries(batch *CatFileBatch, hash Hash) ([]treeEntry, error) {
+	objType, size, rc, err := batch.Read(hash)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	if objType != "tree" {
+		return nil, fmt.Errorf("gitlib: %s is a %s, not a tree", hash, objType)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(rc, data); err != nil {
+		return nil, err
+	}
+	return parseTreeEntries(data, len(hash))
+}
+
+// parseTreeEntries decodes the binary tree object format: repeated
+// "<octal mode> <name>\x00<hashLen-byte hash>" records back to back.
+func parseTreeEntries(data []byte, hashLen int) ([]treeEntry, error) {
+	var entries []treeEntry
+	for len(data) > 0 {
+		sp := bytes.IndexByte(data, ' ')
+		if sp < 0 {
+			return nil, fmt.Errorf("gitlib: malformed tree entry: missing mode separator")
+		}
+		mode, err := strconv.ParseUint(string(data[:sp]), 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("gitlib: malformed tree entry mode: %s", err)
+		}
+		data = data[sp+1:]
+
+		nul := bytes.IndexByte(data, 0)
+		if nul < 0 {
+			return nil, fmt.Errorf("gitlib: malformed tree entry: missing name terminator")
+		}
+		name := string(data[:nul])
+		data = data[nul+1:]
+
+		if len(data) < hashLen {
+			return nil, fmt.Errorf("gitlib: malformed tree entry: truncated hash")
+		}
+		hash := append(Hash(nil), data[:hashLen]...)
+		data = data[hashLen:]
+
+		entries = append(entries, treeEntry{mode: uint32(mode), name: name, hash: hash})
+	}
+	return entries, nil
+}
+
+// restoreBlob reads blob's full content through batch - releasing the
+// batch's lock as soon as the content is in memory, before touching the
+// filesystem - and then writes it below destDir at path, honoring
+// executable and symlink modes.
+func restoreBlob(batch *CatFileBatch, destDir, path string, mode uint32, blob Hash) error {
+	_, _, rc, err := batch.Read(blob)
+	if err != nil {
+		return err
+	}
+	content, err := io.ReadAll(rc)
+	closeErr := rc.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+
+	dest := filepath.Join(destDir, filepath.FromSlash(path))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	if mode == modeSymlink {
+		return os.Symlink(string(content), dest)
+	}
+
+	perm := os.FileMode(0644)
+	if mode == modeBlobExec {
+		perm = 0755
+	}
+
+	return os.WriteFile(dest, content, perm)
+}
@@ -0,0 +1,73 @@
+package gitlib
+
+import (
+	"bytes"
+	"testing"
+)
+
+func treeRecord(mode, name string, hash []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString(mode)
+	b.WriteByte(' ')
+	b.WriteString(name)
+	b.WriteByte(0)
+	b.Write(hash)
+	return b.Bytes()
+}
+
+func TestParseTreeEntries(t *testing.T) {
+	blobHash := bytes.Repeat([]byte{0xAB}, 20)
+	treeHash := bytes.Repeat([]byte{0xCD}, 20)
+
+	var data []byte
+	data = append(data, treeRecord("100644", "file.txt", blobHash)...)
+	data = append(data, treeRecord("40000", "subdir", treeHash)...)
+	data = append(data, treeRecord("100755", "run.sh", blobHash)...)
+	data = append(data, treeRecord("120000", "link", blobHash)...)
+
+	entries, err := parseTreeEntries(data, 20)
+	if err != nil {
+		t.Fatalf("parseTreeEntries: %v", err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("parseTreeEntries returned %d entries; want 4", len(entries))
+	}
+
+	want := []treeEntry{
+		{mode: modeBlob, name: "file.txt", hash: Hash(blobHash)},
+		{mode: modeTree, name: "subdir", hash: Hash(treeHash)},
+		{mode: modeBlobExec, name: "run.sh", hash: Hash(blobHash)},
+		{mode: modeSymlink, name: "link", hash: Hash(blobHash)},
+	}
+	for i, e := range entries {
+		if e.mode != want[i].mode || e.name != want[i].name || !e.hash.Equal(want[i].hash) {
+			t.Errorf("entry %d = %+v; want %+v", i, e, want[i])
+		}
+	}
+}
+
+func TestParseTreeEntriesEmpty(t *testing.T) {
+	entries, err := parseTreeEntries(nil, 20)
+	if err != nil {
+		t.Fatalf("parseTreeEntries(nil) error: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("parseTreeEntries(nil) = %d entries; want 0", len(entries))
+	}
+}
+
+func TestParseTreeEntriesMalformed(t *testing.T) {
+	blobHash := bytes.Repeat([]byte{0xAB}, 20)
+
+	cases := map[string][]byte{
+		"missing mode separator":  []byte("100644file.txt\x00" + string(blobHash)),
+		"non-octal mode":          []byte("10064x file.txt\x00" + string(blobHash)),
+		"missing name terminator": []byte("100644 file.txt" + string(blobHash)),
+		"truncated hash":          []byte("100644 file.txt\x00" + string(blobHash[:5])),
+	}
+	for name, data := range cases {
+		if _, err := parseTreeEntries(data, 20); err == nil {
+			t.Errorf("%s: parseTreeEntries = nil error; want error", name)
+		}
+	}
+}